@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/spf13/pflag"
+)
+
+var (
+	bearerToken     string
+	bearerTokenFile string
+	basicAuth       string
+	tlsCA           string
+	tlsCert         string
+	tlsKey          string
+	tlsInsecure     bool
+	headers         []string
+	publicURL       string
+)
+
+func init() {
+	pflag.StringVar(&bearerToken, "bearer-token", "", "bearer token sent as the Authorization header on every request")
+	pflag.StringVar(&bearerTokenFile, "bearer-token-file", "", "file containing a bearer token (takes precedence over --bearer-token)")
+	pflag.StringVar(&basicAuth, "basic-auth", "", "HTTP basic auth credentials, in user:pass form")
+	pflag.StringVar(&tlsCA, "tls-ca", "", "PEM file of CA certificates to trust for the Prometheus server(s)")
+	pflag.StringVar(&tlsCert, "tls-cert", "", "PEM client certificate for mTLS (requires --tls-key)")
+	pflag.StringVar(&tlsKey, "tls-key", "", "PEM client key for mTLS (requires --tls-cert)")
+	pflag.BoolVar(&tlsInsecure, "tls-insecure", false, "skip TLS certificate verification")
+	pflag.StringArrayVar(&headers, "header", nil, "extra request header in 'Name: Value' form; repeatable")
+	pflag.StringVar(&publicURL, "public-url", "", "browsable URL shown in the Nagios output, in place of the (possibly credentialed) -H host")
+}
+
+// buildRoundTripper turns the auth/TLS/header flags into an http.RoundTripper
+// for api.NewClient, mirroring how Prometheus's own
+// config.NewRoundTripperFromConfig layers auth and TLS over a base transport.
+func buildRoundTripper() (http.RoundTripper, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+
+	if tlsCA != "" || tlsCert != "" || tlsKey != "" || tlsInsecure {
+		tlsConfig := &tls.Config{InsecureSkipVerify: tlsInsecure}
+
+		if tlsCA != "" {
+			caBytes, err := os.ReadFile(tlsCA)
+			if err != nil {
+				return nil, fmt.Errorf("reading --tls-ca: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caBytes) {
+				return nil, fmt.Errorf("no certificates found in --tls-ca %s", tlsCA)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if tlsCert != "" || tlsKey != "" {
+			if tlsCert == "" || tlsKey == "" {
+				return nil, fmt.Errorf("--tls-cert and --tls-key must be set together")
+			}
+			cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+			if err != nil {
+				return nil, fmt.Errorf("loading --tls-cert/--tls-key: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		base.TLSClientConfig = tlsConfig
+	}
+
+	token := bearerToken
+	if bearerTokenFile != "" {
+		data, err := os.ReadFile(bearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading --bearer-token-file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	var basicUser, basicPass string
+	if basicAuth != "" {
+		parts := strings.SplitN(basicAuth, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("--basic-auth must be in user:pass form")
+		}
+		basicUser, basicPass = parts[0], parts[1]
+	}
+
+	extraHeaders := http.Header{}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("--header %q must be in 'Name: Value' form", h)
+		}
+		extraHeaders.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	if token == "" && basicUser == "" && len(extraHeaders) == 0 && base.TLSClientConfig == nil {
+		return api.DefaultRoundTripper, nil
+	}
+
+	return &authRoundTripper{
+		next:        base,
+		bearerToken: token,
+		basicUser:   basicUser,
+		basicPass:   basicPass,
+		headers:     extraHeaders,
+	}, nil
+}
+
+// authRoundTripper layers bearer/basic auth and static extra headers onto a
+// base transport, leaving the underlying connection handling untouched.
+type authRoundTripper struct {
+	next        http.RoundTripper
+	bearerToken string
+	basicUser   string
+	basicPass   string
+	headers     http.Header
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	if rt.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+rt.bearerToken)
+	}
+	if rt.basicUser != "" {
+		req.SetBasicAuth(rt.basicUser, rt.basicPass)
+	}
+	for name, values := range rt.headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+
+	return rt.next.RoundTrip(req)
+}
+
+// displayHost returns the host operators should see in Nagios output:
+// --public-url if set, otherwise the (possibly credential-bearing) -H host
+// that actually served the result.
+func displayHost(servedBy string) string {
+	if publicURL != "" {
+		return publicURL
+	}
+	return servedBy
+}
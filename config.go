@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/segfaultax/go-nagios"
+	"gopkg.in/yaml.v3"
+)
+
+// checkConfigRange mirrors the top-level --range-* flags, scoped to a
+// single check in a config file. Duration takes precedence over Start,
+// the same as --range-duration does over --range-start.
+type checkConfigRange struct {
+	Duration string `yaml:"duration,omitempty"`
+	Start    string `yaml:"start,omitempty"`
+	End      string `yaml:"end,omitempty"`
+	Step     string `yaml:"step"`
+}
+
+// checkConfig is one named check inside a --config file.
+type checkConfig struct {
+	Name        string            `yaml:"name"`
+	Query       string            `yaml:"query"`
+	Warning     string            `yaml:"warning"`
+	Critical    string            `yaml:"critical"`
+	Aggregate   string            `yaml:"aggregate,omitempty"`
+	Range       *checkConfigRange `yaml:"range,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	LabelKeys   []string          `yaml:"label_keys,omitempty"`
+	MinMatching int               `yaml:"min_matching,omitempty"`
+	MaxMatching *int              `yaml:"max_matching,omitempty"`
+	AbsentOK    bool              `yaml:"absent_ok,omitempty"`
+}
+
+// checkConfigFile is the top-level document read from --config.
+type checkConfigFile struct {
+	Checks []checkConfig `yaml:"checks"`
+}
+
+// loadCheckConfigFile reads and validates a --config file.
+func loadCheckConfigFile(path string) (*checkConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	var cf checkConfigFile
+	if err := yaml.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if len(cf.Checks) == 0 {
+		return nil, fmt.Errorf("config %s defines no checks", path)
+	}
+	for i, c := range cf.Checks {
+		if c.Name == "" {
+			return nil, fmt.Errorf("config %s: check %d is missing a name", path, i)
+		}
+		if c.Query == "" {
+			return nil, fmt.Errorf("config %s: check %q is missing a query", path, c.Name)
+		}
+	}
+
+	return &cf, nil
+}
+
+// checkOutcome is the result of running one checkConfig entry, ready to be
+// folded into the combined Nagios output.
+type checkOutcome struct {
+	Name    string
+	Status  int
+	Value   float64
+	Message string
+	Perf    string
+}
+
+// runConfigChecks loads path, runs every check concurrently against the
+// shared -H host(s), and emits a single Nagios result whose status is the
+// worst of the child statuses before exiting with that status.
+func runConfigChecks(path string) {
+	cf, err := loadCheckConfigFile(path)
+	if err != nil {
+		printUsageErrorAndExit(3, err)
+	}
+
+	outcomes := make([]checkOutcome, len(cf.Checks))
+	var wg sync.WaitGroup
+	for i, cfg := range cf.Checks {
+		wg.Add(1)
+		go func(i int, cfg checkConfig) {
+			defer wg.Done()
+			outcomes[i] = runSingleConfigCheck(cfg)
+		}(i, cfg)
+	}
+	wg.Wait()
+
+	overall := 0
+	perfParts := make([]string, 0, len(outcomes))
+	longOutput := make([]string, 0, len(outcomes))
+	for _, o := range outcomes {
+		overall = worstNagiosStatus(overall, o.Status)
+		longOutput = append(longOutput, fmt.Sprintf("%s %s: %s", nagiosStatusLabel(o.Status), o.Name, o.Message))
+		if o.Perf != "" {
+			perfParts = append(perfParts, o.Perf)
+		}
+	}
+
+	fmt.Printf("%s: %d/%d checks not OK | %s\n", nagiosStatusLabel(overall), countNotOK(outcomes), len(outcomes), joinPerfData(perfParts))
+	for _, line := range longOutput {
+		fmt.Println(line)
+	}
+	os.Exit(overall)
+}
+
+// runSingleConfigCheck evaluates one checkConfig entry against the shared
+// hosts via judgeVector, returning its overall status, message, and
+// per-series perfdata.
+func runSingleConfigCheck(cfg checkConfig) checkOutcome {
+	useRange := cfg.Range != nil
+	agg := cfg.Aggregate
+	if agg == "" {
+		agg = "avg"
+	}
+
+	var rng v1.Range
+	if useRange {
+		step := rangeStep
+		if cfg.Range.Step != "" {
+			step = cfg.Range.Step
+		}
+		stepDur, err := time.ParseDuration(step)
+		if err != nil {
+			return checkOutcome{Name: cfg.Name, Status: 3, Message: fmt.Sprintf("invalid range step: %v", err)}
+		}
+
+		end := time.Now()
+		if cfg.Range.End != "" {
+			end, err = time.Parse(time.RFC3339, cfg.Range.End)
+			if err != nil {
+				return checkOutcome{Name: cfg.Name, Status: 3, Message: fmt.Sprintf("invalid range end: %v", err)}
+			}
+		}
+
+		var start time.Time
+		switch {
+		case cfg.Range.Duration != "":
+			dur, err := time.ParseDuration(cfg.Range.Duration)
+			if err != nil {
+				return checkOutcome{Name: cfg.Name, Status: 3, Message: fmt.Sprintf("invalid range duration: %v", err)}
+			}
+			start = end.Add(-dur)
+		case cfg.Range.Start != "":
+			start, err = time.Parse(time.RFC3339, cfg.Range.Start)
+			if err != nil {
+				return checkOutcome{Name: cfg.Name, Status: 3, Message: fmt.Sprintf("invalid range start: %v", err)}
+			}
+		default:
+			return checkOutcome{Name: cfg.Name, Status: 3, Message: "range requires a duration or start"}
+		}
+
+		rng = v1.Range{Start: start, End: end, Step: stepDur}
+	}
+
+	vec, servedBy, err := queryWithFallback(cfg.Query, useRange, rng, agg)
+	if err != nil {
+		return checkOutcome{Name: cfg.Name, Status: 3, Message: fmt.Sprintf("error querying Prometheus: %v", err)}
+	}
+
+	if len(cfg.Labels) > 0 {
+		vec = filterVectorByLabels(vec, cfg.Labels)
+	}
+
+	maxMatching := -1
+	if cfg.MaxMatching != nil {
+		maxMatching = *cfg.MaxMatching
+	}
+
+	check, err := nagios.NewRangeCheckParse(cfg.Warning, cfg.Critical)
+	if err != nil {
+		return checkOutcome{Name: cfg.Name, Status: 3, Message: fmt.Sprintf("invalid thresholds: %v", err)}
+	}
+
+	verdict := judgeVector(vec, cfg.LabelKeys, cfg.MinMatching, maxMatching, cfg.AbsentOK, useRange, agg, func(v float64) int {
+		check.CheckValue(v)
+		return check.Status.ExitCode
+	})
+
+	perfParts := make([]string, 0, len(verdict.Series))
+	for _, s := range verdict.Series {
+		perfParts = append(perfParts, fmt.Sprintf("%s_%s=%g", cfg.Name, s.PerfName, s.Value))
+	}
+
+	message := verdict.Message
+	if servedBy != "" {
+		message = fmt.Sprintf("%s [served by %s]", message, displayHost(servedBy))
+	}
+
+	return checkOutcome{
+		Name:    cfg.Name,
+		Status:  verdict.Status,
+		Value:   worstSeriesValue(verdict.Series),
+		Message: message,
+		Perf:    strings.Join(perfParts, " "),
+	}
+}
+
+// worstSeriesValue returns the value of the most severe series (by Nagios
+// exit code) so the check_prometheus_value gauge has a defined, stable
+// meaning for multi-series checks instead of depending on the arbitrary
+// order the Prometheus API happened to return series in.
+func worstSeriesValue(series []seriesEvaluation) float64 {
+	if len(series) == 0 {
+		return 0
+	}
+	worst := series[0]
+	for _, s := range series[1:] {
+		if s.Status > worst.Status {
+			worst = s
+		}
+	}
+	return worst.Value
+}
+
+// filterVectorByLabels keeps only the series whose metric carries every
+// label in labels with a matching value.
+func filterVectorByLabels(vec model.Vector, labels map[string]string) model.Vector {
+	filtered := make(model.Vector, 0, len(vec))
+outer:
+	for _, sample := range vec {
+		for k, v := range labels {
+			if string(sample.Metric[model.LabelName(k)]) != v {
+				continue outer
+			}
+		}
+		filtered = append(filtered, sample)
+	}
+	return filtered
+}
+
+// countNotOK returns how many outcomes are not status OK(0).
+func countNotOK(outcomes []checkOutcome) int {
+	n := 0
+	for _, o := range outcomes {
+		if o.Status != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// joinPerfData joins already-formatted perfdata chunks with a space, the
+// Nagios plugin convention for multiple perfdata points.
+func joinPerfData(parts []string) string {
+	sort.Strings(parts)
+	out := ""
+	for i, p := range parts {
+		if i > 0 {
+			out += " "
+		}
+		out += p
+	}
+	return out
+}
+
+// nagiosStatusLabel renders a Nagios exit code as its plugin-output word.
+func nagiosStatusLabel(status int) string {
+	switch status {
+	case 0:
+		return "OK"
+	case 1:
+		return "WARNING"
+	case 2:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// nagiosStatusRank orders Nagios exit codes by severity (OK < UNKNOWN <
+// WARNING < CRITICAL) rather than by their raw numeric value, under which
+// UNKNOWN(3) would outrank CRITICAL(2).
+func nagiosStatusRank(status int) int {
+	switch status {
+	case 0:
+		return 0
+	case 3:
+		return 1
+	case 1:
+		return 2
+	case 2:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// worstNagiosStatus returns whichever of a and b is more severe under
+// Nagios's OK < UNKNOWN < WARNING < CRITICAL ordering, so a combined result
+// can't have one UNKNOWN child mask a CRITICAL sibling.
+func worstNagiosStatus(a, b int) int {
+	if nagiosStatusRank(b) > nagiosStatusRank(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestWorstNagiosStatus(t *testing.T) {
+	cases := []struct {
+		name     string
+		statuses []int
+		want     int
+	}{
+		{"all ok", []int{0, 0}, 0},
+		{"warning beats ok", []int{0, 1}, 1},
+		{"critical beats unknown", []int{2, 3}, 2},
+		{"warning beats unknown", []int{1, 3}, 1},
+		{"critical beats everything", []int{0, 1, 2, 3}, 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			overall := 0
+			for _, s := range c.statuses {
+				overall = worstNagiosStatus(overall, s)
+			}
+			if overall != c.want {
+				t.Errorf("worstNagiosStatus(%v) = %d, want %d", c.statuses, overall, c.want)
+			}
+		})
+	}
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"math"
+	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,13 +19,30 @@ import (
 )
 
 var (
-	showHelp   bool
-	warning    string
-	critical   string
-	host       string
-	metricName string
-	query      string
-	timeout    int
+	showHelp        bool
+	warning         string
+	critical        string
+	hosts           []string
+	metricName      string
+	query           string
+	timeout         int
+	fallbackTimeout int
+
+	rangeMode     bool
+	rangeStart    string
+	rangeEnd      string
+	rangeDuration string
+	rangeStep     string
+	aggregate     string
+
+	configPath string
+
+	clientRoundTripper http.RoundTripper
+
+	labelKeys   []string
+	minMatching int
+	maxMatching int
+	absentOk    bool
 )
 
 const usage string = `usage: go-check-prometheus [options]
@@ -36,11 +56,55 @@ Meaning: The sum of all non-null values returned by the Prometheus query
 10 but less than or equal to 100, critical if greater than 100. If it's
 less than zero, it's critical.
 ullcnt / total points)
+
+-H may be repeated or comma-separated to provide fallback Prometheus
+servers. If the primary host errors, returns an empty vector, or warns
+that a metric is missing, the remaining hosts are tried in order until
+--fallback-timeout is exhausted, and the Nagios output notes which host
+actually served the result.
+
+--range switches to a range query (v1api.QueryRange) instead of an
+instant one, reducing each returned series to a single value with
+--aggregate before threshold evaluation. --range-end defaults to now;
+the range start comes from --range-duration (e.g. '15m' before the end)
+or an explicit --range-start, and --range-step controls the query
+resolution. --aggregate accepts min, max, avg, sum, last, stddev,
+count, or percentile:N (e.g. percentile:95).
+
+--config points to a YAML file defining multiple named checks (query,
+warning/critical, optional aggregate/range, optional label filters).
+All of them run concurrently against the same -H host(s), and a single
+combined Nagios result is emitted whose status is the worst of the
+per-check statuses. -q/-w/-c/-n and the range flags are ignored in this
+mode, except --range-step, which is the step for a check whose range
+sets none; see config.go for the file format.
+
+--serve keeps the process running instead of exiting: it re-executes
+the configured check(s) (-q/-w/-c, or every check in --config) every
+--interval, publishes check_prometheus_status/value/duration_seconds
+gauges on /metrics for promhttp to scrape, and serves the latest
+Nagios-formatted text on /check for scrape-and-forward setups.
+--listen controls the address it binds.
+
+--bearer-token/--bearer-token-file, --basic-auth 'user:pass', --tls-ca/
+--tls-cert/--tls-key/--tls-insecure, and repeatable --header 'Name:
+Value' secure the connection to Prometheus servers that sit behind auth
+proxies, mTLS ingresses, or multi-tenant Cortex/Thanos setups (e.g. an
+X-Scope-OrgID header). --public-url, if set, replaces the -H host shown
+in the Nagios output with a browsable URL that carries no credentials.
+
+Every returned series is evaluated against -w/-c independently and gets
+its own perfdata point, named from --label-key (repeatable; label
+values joined with '_') or the full metric if --label-key is unset.
+--min-matching/--max-matching turn "too few series returned" and "too
+many CRITICAL series" into distinct CRITICAL results instead of
+silently picking one series, and --absent-ok makes a query that
+legitimately returns nothing report OK rather than CRITICAL.
 `
 
 func init() {
 	pflag.BoolVarP(&showHelp, "help", "h", false, "show help")
-	pflag.StringVarP(&host, "host", "H", "", "prometheus host")
+	pflag.StringSliceVarP(&hosts, "host", "H", nil, "prometheus host; repeat or comma-separate to add fallback hosts")
 
 	pflag.StringVarP(&warning, "warning", "w", "", "warning range")
 	pflag.StringVarP(&critical, "critical", "c", "", "critical range")
@@ -49,6 +113,21 @@ func init() {
 	pflag.StringVarP(&query, "query", "q", "", "prometheus query")
 
 	pflag.IntVarP(&timeout, "timeout", "t", 10, "Execution timeout")
+	pflag.IntVar(&fallbackTimeout, "fallback-timeout", 5, "total seconds budget for trying fallback hosts after the primary one fails")
+
+	pflag.BoolVar(&rangeMode, "range", false, "run a range query and aggregate the resulting matrix instead of an instant query")
+	pflag.StringVar(&rangeStart, "range-start", "", "range query start time, RFC3339 (ignored if --range-duration is set)")
+	pflag.StringVar(&rangeEnd, "range-end", "", "range query end time, RFC3339 (default: now)")
+	pflag.StringVar(&rangeDuration, "range-duration", "", "range query lookback duration ending at --range-end, e.g. '15m'")
+	pflag.StringVar(&rangeStep, "range-step", "1m", "range query resolution step")
+	pflag.StringVar(&aggregate, "aggregate", "avg", "aggregation applied to each series' range samples: min|max|avg|sum|last|percentile:N|stddev|count")
+
+	pflag.StringVar(&configPath, "config", "", "path to a YAML file defining multiple named checks to run as one combined result")
+
+	pflag.StringArrayVar(&labelKeys, "label-key", nil, "label name used to build each series' perfdata metric name; repeatable, joined with '_'")
+	pflag.IntVar(&minMatching, "min-matching", 0, "minimum number of series the query must return (0 disables the check)")
+	pflag.IntVar(&maxMatching, "max-matching", -1, "maximum number of CRITICAL series; more than this gives a distinct 'too many CRITICAL series' result (-1 disables the check). Any CRITICAL series still makes the result CRITICAL")
+	pflag.BoolVar(&absentOk, "absent-ok", false, "treat a query that returns no series as OK instead of CRITICAL")
 }
 
 func main() {
@@ -59,21 +138,55 @@ func main() {
 		os.Exit(0)
 	}
 
-	err := checkRequiredOptions()
+	for i, h := range hosts {
+		hosts[i] = normalizeHost(h)
+	}
+
+	rt, err := buildRoundTripper()
 	if err != nil {
 		printUsageErrorAndExit(3, err)
 	}
+	clientRoundTripper = rt
 
-	if !(strings.HasPrefix(host, "https://") || strings.HasPrefix(host, "http://")) {
-		host = "http://" + host
+	if configPath != "" {
+		if len(hosts) == 0 {
+			printUsageErrorAndExit(3, fmt.Errorf("host is required"))
+		}
+		if serveMode {
+			cf, err := loadCheckConfigFile(configPath)
+			if err != nil {
+				printUsageErrorAndExit(3, err)
+			}
+			runServe(cf.Checks)
+			return
+		}
+		runConfigChecks(configPath)
+		return
 	}
 
-	client, err := api.NewClient(api.Config{
-		Address: host,
-	})
+	err = checkRequiredOptions()
 	if err != nil {
-		fmt.Printf("Error creating client: %v\n", err)
-		os.Exit(1)
+		printUsageErrorAndExit(3, err)
+	}
+
+	if serveMode {
+		var rng *checkConfigRange
+		if rangeMode {
+			rng = &checkConfigRange{Duration: rangeDuration, Start: rangeStart, End: rangeEnd, Step: rangeStep}
+		}
+		runServe([]checkConfig{{
+			Name:        metricName,
+			Query:       query,
+			Warning:     warning,
+			Critical:    critical,
+			Aggregate:   aggregate,
+			Range:       rng,
+			LabelKeys:   labelKeys,
+			MinMatching: minMatching,
+			MaxMatching: &maxMatching,
+			AbsentOK:    absentOk,
+		}})
+		return
 	}
 
 	check, err := nagios.NewRangeCheckParse(warning, critical)
@@ -83,59 +196,381 @@ func main() {
 	}
 	defer check.Done()
 
-	v1api := v1.NewAPI(client)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	defer cancel()
-	result, warnings, err := v1api.Query(ctx, query, time.Now())
+	var rng v1.Range
+	if rangeMode {
+		rng, err = resolveRange()
+		if err != nil {
+			printUsageErrorAndExit(3, err)
+		}
+	}
+
+	vec, servedBy, err := queryWithFallback(query, rangeMode, rng, aggregate)
 	if err != nil {
 		check.Unknown("Error querying Prometheus: %v", err)
 		return
 	}
-	if len(warnings) > 0 {
-		fmt.Printf("Warnings: %v\n", warnings)
+
+	verdict := judgeVector(vec, labelKeys, minMatching, maxMatching, absentOk, rangeMode, aggregate, func(v float64) int {
+		check.CheckValue(v)
+		return check.Status.ExitCode
+	})
+
+	for _, s := range verdict.Series {
+		check.AddPerfData(nagios.NewPerfData(s.PerfName, s.Value, ""))
 	}
-	vec := result.(model.Vector)
 
-	if len(result.String()) == 0 {
-		check.Unknown("The query did not return any result")
-		return
+	check.Status.ExitCode = verdict.Status
+	check.Status.Label = nagiosStatusLabel(verdict.Status)
+	if servedBy != "" {
+		check.SetMessage("%s: %s [served by %s]", metricName, verdict.Message, displayHost(servedBy))
+	} else {
+		check.SetMessage("%s: %s", metricName, verdict.Message)
+	}
+}
+
+// seriesEvaluation is one returned series checked against -w/-c, with the
+// perfdata name it should be reported under.
+type seriesEvaluation struct {
+	PerfName string
+	Value    float64
+	Status   int
+}
+
+// vectorVerdict is the outcome of judging an entire vector: its overall
+// Nagios status, a human-readable summary, and every series that was
+// evaluated (so callers can still emit perfdata even when min/max-matching
+// overrides the status).
+type vectorVerdict struct {
+	Status  int
+	Message string
+	Series  []seriesEvaluation
+}
+
+// judgeVector evaluates every series in vec against thresholds (via
+// checkValue, which should apply -w/-c and return the resulting exit code),
+// instead of picking a single "worst" series and discarding the rest. It
+// replaces the previous pattern of double-calling CheckValue on one series
+// and dropping perfdata for the others. useRange and agg are folded into
+// each series' perfdata name so a --range check's perfdata says which
+// aggregation produced the value.
+//
+// An empty vec is CRITICAL unless absentOk is set, in which case it's OK.
+// minMatching (if > 0) requires at least that many series to be returned.
+// maxMatching (if >= 0) turns more than that many CRITICAL series into a
+// CRITICAL result with its own message; it does not lower the status when
+// fewer series are CRITICAL. Otherwise the overall status is the worst
+// across all series.
+func judgeVector(vec model.Vector, labelKeys []string, minMatching, maxMatching int, absentOk bool, useRange bool, agg string, checkValue func(float64) int) vectorVerdict {
+	if len(vec) == 0 {
+		if absentOk {
+			return vectorVerdict{Status: 0, Message: "no matching series (expected, --absent-ok set)"}
+		}
+		return vectorVerdict{Status: 2, Message: "the query returned no series"}
+	}
+
+	series := make([]seriesEvaluation, len(vec))
+	critCount := 0
+	worst := 0
+	for i, sample := range vec {
+		val := float64(sample.Value)
+		status := checkValue(val)
+		series[i] = seriesEvaluation{PerfName: perfMetricName(sample, labelKeys, useRange, agg), Value: val, Status: status}
+		if status == 2 {
+			critCount++
+		}
+		if status > worst {
+			worst = status
+		}
 	}
 
-	var finalExitStatus int
-	var outputCheckIdx int
+	if minMatching > 0 && len(series) < minMatching {
+		return vectorVerdict{
+			Status:  2,
+			Series:  series,
+			Message: fmt.Sprintf("only %d series matched, want at least %d", len(series), minMatching),
+		}
+	}
+	if maxMatching >= 0 && critCount > maxMatching {
+		return vectorVerdict{
+			Status:  2,
+			Series:  series,
+			Message: fmt.Sprintf("%d series are CRITICAL, want at most %d", critCount, maxMatching),
+		}
+	}
 
-	// If multiple metrics returned, this will return metric with a non-OK status
-	for metricIdx, metric := range vec {
-		fmt.Println("")
-		checkVal := float64(metric.Value)
-		check.CheckValue(checkVal)
-		//Keep looping if WARN and UNKNOWN, break if CRIT is found otherwise set largest non-OK check status
-		if check.Status.ExitCode == 2 {
-			outputCheckIdx = metricIdx
+	return vectorVerdict{
+		Status:  worst,
+		Series:  series,
+		Message: fmt.Sprintf("%d series evaluated, %d CRITICAL", len(series), critCount),
+	}
+}
+
+// perfNameReplacer strips every character Metric.String() can produce that
+// an unquoted Nagios perfdata label can't contain: spaces (it separates
+// labels with ", "), and '=', '"', '{', '}', ',' from the "name{k="v"}"
+// rendering itself. go-nagios.PerfData.String() does no escaping, so this
+// has to happen before the label reaches it.
+var perfNameReplacer = strings.NewReplacer(" ", "", "=", "_", "\"", "", "{", "_", "}", "", ",", "_")
+
+// perfMetricName names a series' perfdata point from labelKeys (joined with
+// '_'), or falls back to the series' full metric string, sanitized via
+// perfNameReplacer, when none are set. When useRange is set, the
+// aggregation name is folded in too, since a range series' value only
+// makes sense alongside what produced it (e.g. 'max').
+func perfMetricName(sample *model.Sample, labelKeys []string, useRange bool, agg string) string {
+	name := sample.Metric.String()
+	if len(labelKeys) > 0 {
+		parts := make([]string, 0, len(labelKeys))
+		for _, k := range labelKeys {
+			parts = append(parts, string(sample.Metric[model.LabelName(k)]))
+		}
+		name = strings.Join(parts, "_")
+	} else {
+		name = perfNameReplacer.Replace(name)
+	}
+	if useRange {
+		name = fmt.Sprintf("%s_%s", name, agg)
+	}
+	return name
+}
+
+// queryWithFallback runs q (instant, or range+agg when useRange is set)
+// against hosts[0], then, within fallbackTimeout seconds, against the
+// remaining hosts in order until one returns a usable non-empty vector. It
+// returns the vector and the host that served it, or an empty servedBy and
+// the last error if none succeeded. hosts, timeout, and fallbackTimeout are
+// shared across every caller, since they describe one logical Prometheus
+// endpoint (with its failover peers), not a single query.
+func queryWithFallback(q string, useRange bool, rng v1.Range, agg string) (model.Vector, string, error) {
+	var fallbackDeadline time.Time
+
+	var lastErr error
+	for i, h := range hosts {
+		if i == 1 {
+			fallbackDeadline = time.Now().Add(time.Duration(fallbackTimeout) * time.Second)
+		}
+		if i > 0 && time.Now().After(fallbackDeadline) {
 			break
-		} else if check.Status.ExitCode > finalExitStatus {
-			finalExitStatus = check.Status.ExitCode
-			outputCheckIdx = metricIdx
 		}
+
+		client, err := api.NewClient(api.Config{Address: h, RoundTripper: clientRoundTripper})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		v1api := v1.NewAPI(client)
+		deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+		if i > 0 && fallbackDeadline.Before(deadline) {
+			deadline = fallbackDeadline
+		}
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+
+		var vec model.Vector
+		var warnings v1.Warnings
+		if useRange {
+			var result model.Value
+			result, warnings, err = v1api.QueryRange(ctx, q, rng)
+			cancel()
+			if err == nil {
+				matrix, ok := result.(model.Matrix)
+				if !ok {
+					err = fmt.Errorf("range query did not return a matrix")
+				} else {
+					vec, err = aggregateMatrix(matrix, agg)
+				}
+			}
+		} else {
+			var result model.Value
+			result, warnings, err = v1api.Query(ctx, q, time.Now())
+			cancel()
+			if err == nil {
+				var ok bool
+				vec, ok = result.(model.Vector)
+				if !ok {
+					err = fmt.Errorf("query did not return a vector")
+				}
+			}
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		lastErr = nil
+		if len(warnings) > 0 {
+			fmt.Printf("Warnings from %s: %v\n", h, warnings)
+			if isMissingMetricWarning(warnings) {
+				continue
+			}
+		}
+		if len(vec) == 0 {
+			continue
+		}
+
+		return vec, h, nil
 	}
 
-	outputCheck := vec[outputCheckIdx]
-	valStr := outputCheck.Value.String()
+	return nil, "", lastErr
+}
 
-	val, _ := strconv.ParseFloat(valStr, 64)
+// resolveRange turns the --range-* flags into a v1.Range, preferring
+// --range-duration over --range-start when both are set; it is an error
+// when neither is.
+func resolveRange() (v1.Range, error) {
+	step, err := time.ParseDuration(rangeStep)
 	if err != nil {
-		printUsageErrorAndExit(3, err)
+		return v1.Range{}, fmt.Errorf("invalid --range-step: %w", err)
+	}
+
+	end := time.Now()
+	if rangeEnd != "" {
+		end, err = time.Parse(time.RFC3339, rangeEnd)
+		if err != nil {
+			return v1.Range{}, fmt.Errorf("invalid --range-end: %w", err)
+		}
+	}
+
+	var start time.Time
+	switch {
+	case rangeDuration != "":
+		dur, err := time.ParseDuration(rangeDuration)
+		if err != nil {
+			return v1.Range{}, fmt.Errorf("invalid --range-duration: %w", err)
+		}
+		start = end.Add(-dur)
+	case rangeStart != "":
+		start, err = time.Parse(time.RFC3339, rangeStart)
+		if err != nil {
+			return v1.Range{}, fmt.Errorf("invalid --range-start: %w", err)
+		}
+	default:
+		return v1.Range{}, fmt.Errorf("--range requires --range-start or --range-duration")
+	}
+
+	return v1.Range{Start: start, End: end, Step: step}, nil
+}
+
+// aggregateMatrix reduces each series of a range query's matrix to a single
+// sample using agg, keyed by the series' labels.
+func aggregateMatrix(matrix model.Matrix, agg string) (model.Vector, error) {
+	vec := make(model.Vector, 0, len(matrix))
+	for _, stream := range matrix {
+		val, err := aggregateSamples(stream.Values, agg)
+		if err != nil {
+			return nil, fmt.Errorf("aggregating series %s: %w", stream.Metric, err)
+		}
+		vec = append(vec, &model.Sample{
+			Metric:    stream.Metric,
+			Value:     model.SampleValue(val),
+			Timestamp: stream.Values[len(stream.Values)-1].Timestamp,
+		})
+	}
+	return vec, nil
+}
+
+// aggregateSamples reduces a series' range samples to a single float64 per
+// the --aggregate operator (min|max|avg|sum|last|percentile:N|stddev|count).
+func aggregateSamples(points []model.SamplePair, agg string) (float64, error) {
+	if len(points) == 0 {
+		return 0, fmt.Errorf("no samples in range")
+	}
+
+	values := make([]float64, len(points))
+	for i, p := range points {
+		values[i] = float64(p.Value)
+	}
+
+	switch {
+	case agg == "min":
+		min := values[0]
+		for _, v := range values[1:] {
+			if v < min {
+				min = v
+			}
+		}
+		return min, nil
+	case agg == "max":
+		max := values[0]
+		for _, v := range values[1:] {
+			if v > max {
+				max = v
+			}
+		}
+		return max, nil
+	case agg == "sum":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum, nil
+	case agg == "avg":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values)), nil
+	case agg == "last":
+		return values[len(values)-1], nil
+	case agg == "count":
+		return float64(len(values)), nil
+	case agg == "stddev":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		mean := sum / float64(len(values))
+		var variance float64
+		for _, v := range values {
+			variance += (v - mean) * (v - mean)
+		}
+		variance /= float64(len(values))
+		return math.Sqrt(variance), nil
+	case strings.HasPrefix(agg, "percentile:"):
+		pctStr := strings.TrimPrefix(agg, "percentile:")
+		pct, err := strconv.ParseFloat(pctStr, 64)
+		if err != nil || pct < 0 || pct > 100 {
+			return 0, fmt.Errorf("invalid percentile %q", pctStr)
+		}
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		idx := int(math.Ceil(pct/100*float64(len(sorted)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= len(sorted) {
+			idx = len(sorted) - 1
+		}
+		return sorted[idx], nil
+	default:
+		return 0, fmt.Errorf("unknown aggregation %q", agg)
 	}
+}
 
-	check.CheckValue(val)
-	check.AddPerfData(nagios.NewPerfData(vec.String(), val, ""))
-	check.SetMessage("%s (%s is %s)", metricName, outputCheck.Metric, valStr)
+// isMissingMetricWarning reports whether any of the query warnings indicate
+// that the series being queried doesn't exist on this server, which is the
+// signal to try the next fallback host rather than trusting an empty result.
+func isMissingMetricWarning(warnings v1.Warnings) bool {
+	for _, w := range warnings {
+		lower := strings.ToLower(w)
+		if strings.Contains(lower, "no data") || strings.Contains(lower, "unknown metric") || strings.Contains(lower, "not found") {
+			return true
+		}
+	}
+	return false
+}
 
+// normalizeHost ensures a host flag value carries an explicit scheme so it
+// can be handed straight to api.NewClient.
+func normalizeHost(h string) string {
+	if strings.HasPrefix(h, "https://") || strings.HasPrefix(h, "http://") {
+		return h
+	}
+	return "http://" + h
 }
 
 func checkRequiredOptions() error {
 	switch {
-	case host == "":
+	case len(hosts) == 0:
 		return fmt.Errorf("host is required")
 	case query == "":
 		return fmt.Errorf("query is required")
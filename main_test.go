@@ -0,0 +1,226 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+func sample(labels model.Metric, value float64) *model.Sample {
+	return &model.Sample{Metric: labels, Value: model.SampleValue(value)}
+}
+
+func TestPerfMetricNameRangeIncludesAggregate(t *testing.T) {
+	s := sample(model.Metric{"instance": "a", "job": "x"}, 3)
+
+	got := perfMetricName(s, nil, true, "max")
+	want := "_instance_a_job_x_max"
+	if got != want {
+		t.Errorf("perfMetricName() = %q, want %q", got, want)
+	}
+
+	got = perfMetricName(s, []string{"instance"}, true, "max")
+	want = "a_max"
+	if got != want {
+		t.Errorf("perfMetricName() with label keys = %q, want %q", got, want)
+	}
+}
+
+func TestPerfMetricNameFallbackStripsSpaces(t *testing.T) {
+	s := sample(model.Metric{"instance": "a", "job": "x"}, 3)
+
+	got := perfMetricName(s, nil, false, "")
+	for _, c := range []string{" ", "=", "\"", "{", "}", ","} {
+		if strings.Contains(got, c) {
+			t.Errorf("perfMetricName() = %q, must not contain %q (invalid unquoted Nagios perfdata label)", got, c)
+		}
+	}
+}
+
+func TestPerfMetricNameInstantOmitsAggregate(t *testing.T) {
+	s := sample(model.Metric{"instance": "a"}, 3)
+
+	got := perfMetricName(s, []string{"instance"}, false, "max")
+	if got != "a" {
+		t.Errorf("perfMetricName() = %q, want %q", got, "a")
+	}
+}
+
+func TestJudgeVectorWorstStatusWins(t *testing.T) {
+	vec := model.Vector{
+		sample(model.Metric{"instance": "a"}, 1),
+		sample(model.Metric{"instance": "b"}, 100),
+	}
+
+	// Pretend -w 10 -c 50: values <=10 are OK(0), >50 are CRITICAL(2).
+	checkValue := func(v float64) int {
+		if v > 50 {
+			return 2
+		}
+		return 0
+	}
+
+	verdict := judgeVector(vec, nil, 0, -1, false, false, "", checkValue)
+	if verdict.Status != 2 {
+		t.Errorf("Status = %d, want 2 (CRITICAL)", verdict.Status)
+	}
+	if len(verdict.Series) != 2 {
+		t.Errorf("Series has %d entries, want 2 (perfdata must cover every series)", len(verdict.Series))
+	}
+}
+
+func TestJudgeVectorMinMatchingNotMet(t *testing.T) {
+	vec := model.Vector{sample(model.Metric{"instance": "a"}, 1)}
+	checkValue := func(v float64) int { return 0 }
+
+	verdict := judgeVector(vec, nil, 2, -1, false, false, "", checkValue)
+	if verdict.Status != 2 {
+		t.Errorf("Status = %d, want 2 (CRITICAL, fewer series than --min-matching)", verdict.Status)
+	}
+	if len(verdict.Series) != 1 {
+		t.Errorf("Series has %d entries, want 1 (perfdata must still cover every series)", len(verdict.Series))
+	}
+}
+
+func TestJudgeVectorMaxMatchingExceeded(t *testing.T) {
+	vec := model.Vector{
+		sample(model.Metric{"instance": "a"}, 100),
+		sample(model.Metric{"instance": "b"}, 100),
+		sample(model.Metric{"instance": "c"}, 1),
+	}
+	checkValue := func(v float64) int {
+		if v > 50 {
+			return 2
+		}
+		return 0
+	}
+
+	verdict := judgeVector(vec, nil, 0, 1, false, false, "", checkValue)
+	if verdict.Status != 2 {
+		t.Errorf("Status = %d, want 2 (CRITICAL, 2 CRITICAL series exceeds --max-matching 1)", verdict.Status)
+	}
+}
+
+func TestJudgeVectorAbsentOkEmptyVector(t *testing.T) {
+	checkValue := func(v float64) int { return 2 }
+
+	verdict := judgeVector(nil, nil, 0, -1, true, false, "", checkValue)
+	if verdict.Status != 0 {
+		t.Errorf("Status = %d, want 0 (OK, --absent-ok set)", verdict.Status)
+	}
+	if len(verdict.Series) != 0 {
+		t.Errorf("Series has %d entries, want 0", len(verdict.Series))
+	}
+}
+
+func TestWorstSeriesValue(t *testing.T) {
+	series := []seriesEvaluation{
+		{PerfName: "a", Value: 1, Status: 0},
+		{PerfName: "b", Value: 99, Status: 2},
+		{PerfName: "c", Value: 5, Status: 1},
+	}
+
+	got := worstSeriesValue(series)
+	if got != 99 {
+		t.Errorf("worstSeriesValue() = %v, want 99 (the CRITICAL series)", got)
+	}
+}
+
+func TestAggregateSamplesMaxAndPercentile(t *testing.T) {
+	points := []model.SamplePair{
+		{Value: 1}, {Value: 2}, {Value: 3}, {Value: 4},
+	}
+
+	max, err := aggregateSamples(points, "max")
+	if err != nil || max != 4 {
+		t.Errorf("max = %v, %v, want 4, nil", max, err)
+	}
+
+	p50, err := aggregateSamples(points, "percentile:50")
+	if err != nil || p50 != 2 {
+		t.Errorf("percentile:50 = %v, %v, want 2, nil", p50, err)
+	}
+
+	if _, err := aggregateSamples(points, "bogus"); err == nil {
+		t.Error("expected an error for an unknown aggregation")
+	}
+}
+
+// errorHost and vectorHost spin up single-endpoint Prometheus API servers
+// so queryWithFallback's host loop can be exercised without a real
+// Prometheus.
+func errorHost(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+}
+
+func vectorHost(t *testing.T, vector string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":` + vector + `}}`))
+	}))
+}
+
+func withHosts(t *testing.T, servers ...*httptest.Server) {
+	t.Helper()
+	origHosts, origTimeout, origFallback := hosts, timeout, fallbackTimeout
+	t.Cleanup(func() {
+		hosts, timeout, fallbackTimeout = origHosts, origTimeout, origFallback
+		for _, s := range servers {
+			s.Close()
+		}
+	})
+
+	hosts = make([]string, len(servers))
+	for i, s := range servers {
+		hosts[i] = s.URL
+	}
+	timeout = 5
+	fallbackTimeout = 5
+}
+
+func TestQueryWithFallbackAllHostsError(t *testing.T) {
+	withHosts(t, errorHost(t), errorHost(t))
+
+	_, servedBy, err := queryWithFallback("up", false, v1.Range{}, "")
+	if err == nil {
+		t.Fatal("expected an error when every host fails")
+	}
+	if servedBy != "" {
+		t.Errorf("servedBy = %q, want empty", servedBy)
+	}
+}
+
+func TestQueryWithFallbackErrorThenEmptyIsNotAnError(t *testing.T) {
+	withHosts(t, errorHost(t), vectorHost(t, "[]"))
+
+	vec, _, err := queryWithFallback("up", false, v1.Range{}, "")
+	if err != nil {
+		t.Fatalf("expected no error once a later host returns a clean empty vector, got %v", err)
+	}
+	if len(vec) != 0 {
+		t.Errorf("vec = %v, want empty", vec)
+	}
+}
+
+func TestQueryWithFallbackErrorThenSuccess(t *testing.T) {
+	withHosts(t, errorHost(t), vectorHost(t, `[{"metric":{"instance":"b"},"value":[1,"2"]}]`))
+
+	vec, servedBy, err := queryWithFallback("up", false, v1.Range{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vec) != 1 {
+		t.Fatalf("vec has %d samples, want 1", len(vec))
+	}
+	if servedBy != hosts[1] {
+		t.Errorf("servedBy = %q, want %q", servedBy, hosts[1])
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/pflag"
+)
+
+var (
+	serveMode     bool
+	serveListen   string
+	serveInterval string
+)
+
+func init() {
+	pflag.BoolVar(&serveMode, "serve", false, "keep running, re-executing the configured check(s) on --interval, and expose them on /metrics and /check")
+	pflag.StringVar(&serveListen, "listen", ":9117", "address for --serve to listen on")
+	pflag.StringVar(&serveInterval, "interval", "1m", "how often --serve re-executes the configured check(s)")
+}
+
+var (
+	serveStatusGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "check_prometheus_status",
+		Help: "Nagios exit status of the check (0=OK, 1=WARNING, 2=CRITICAL, 3=UNKNOWN)",
+	}, []string{"name"})
+	serveValueGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "check_prometheus_value",
+		Help: "Value of the check's most severe series, as evaluated against its thresholds",
+	}, []string{"name"})
+	serveDurationGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "check_prometheus_duration_seconds",
+		Help: "How long the check took to execute against Prometheus",
+	}, []string{"name"})
+)
+
+func init() {
+	prometheus.MustRegister(serveStatusGauge, serveValueGauge, serveDurationGauge)
+}
+
+var (
+	latestMu     sync.RWMutex
+	latestOutput string
+)
+
+// runServe repeatedly executes checks on --interval, publishing the results
+// as gauges on /metrics (for promhttp) and as Nagios-formatted text on
+// /check (for scrape-and-forward setups), until the process is killed.
+func runServe(checks []checkConfig) {
+	interval, err := time.ParseDuration(serveInterval)
+	if err != nil {
+		printUsageErrorAndExit(3, fmt.Errorf("invalid --interval: %w", err))
+	}
+
+	runServeOnce(checks)
+
+	go func() {
+		for range time.Tick(interval) {
+			runServeOnce(checks)
+		}
+	}()
+
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
+		latestMu.RLock()
+		defer latestMu.RUnlock()
+		fmt.Fprint(w, latestOutput)
+	})
+
+	fmt.Printf("go-check-prometheus: serving on %s, re-checking every %s\n", serveListen, interval)
+	if err := http.ListenAndServe(serveListen, nil); err != nil {
+		fmt.Printf("serve error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runServeOnce runs every check once, updates the exported gauges, and
+// refreshes the text served from /check.
+func runServeOnce(checks []checkConfig) {
+	var out strings.Builder
+	overall := 0
+
+	for _, cfg := range checks {
+		start := time.Now()
+		outcome := runSingleConfigCheck(cfg)
+		duration := time.Since(start).Seconds()
+
+		serveStatusGauge.WithLabelValues(outcome.Name).Set(float64(outcome.Status))
+		serveValueGauge.WithLabelValues(outcome.Name).Set(outcome.Value)
+		serveDurationGauge.WithLabelValues(outcome.Name).Set(duration)
+
+		overall = worstNagiosStatus(overall, outcome.Status)
+		out.WriteString(fmt.Sprintf("%s %s: %s\n", nagiosStatusLabel(outcome.Status), outcome.Name, outcome.Message))
+	}
+
+	header := fmt.Sprintf("%s: %d check(s)\n", nagiosStatusLabel(overall), len(checks))
+
+	latestMu.Lock()
+	latestOutput = header + out.String()
+	latestMu.Unlock()
+}